@@ -0,0 +1,61 @@
+package settings
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/gofrs/flock"
+)
+
+// withFileLock acquires an advisory lock on a ".lock" file next to path
+// for the duration of fn, so concurrent `circleci` processes racing to
+// load-mutate-save the same settings file don't corrupt or truncate it.
+// The lock file itself is never read from or written to. A shared lock
+// should be used for reads and an exclusive lock for writes.
+func withFileLock(path string, exclusive bool, fn func() error) error {
+	lock := flock.New(path + ".lock")
+	defer lock.Close() // nolint: errcheck
+
+	lockFn := lock.RLock
+	if exclusive {
+		lockFn = lock.Lock
+	}
+	if err := lockFn(); err != nil {
+		return err
+	}
+	defer lock.Unlock() // nolint: errcheck
+
+	return fn()
+}
+
+// writeFileAtomic writes data to path by first writing it to a temp file
+// in the same directory and then renaming it into place, so a reader
+// never observes a partially written file even without the lock above.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // nolint: errcheck - no-op once renamed below
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close() // nolint: errcheck
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close() // nolint: errcheck
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
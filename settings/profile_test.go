@@ -0,0 +1,143 @@
+package settings
+
+import "testing"
+
+func TestAddProfileFirstBecomesActive(t *testing.T) {
+	cfg := &Config{}
+
+	if err := cfg.AddProfile("default", Profile{Host: "https://circleci.com", Endpoint: "graphql-unstable", Token: "sometoken0000000000000000"}); err != nil {
+		t.Fatalf("AddProfile: %s", err)
+	}
+
+	if cfg.CurrentProfile != "default" {
+		t.Fatalf("expected the first profile added to become active, got %q", cfg.CurrentProfile)
+	}
+	if cfg.Host != "https://circleci.com" || cfg.Token != "sometoken0000000000000000" {
+		t.Fatalf("expected the active profile's fields to be resolved onto cfg, got %+v", cfg)
+	}
+}
+
+func TestAddProfileAdditionalDoesNotSwitchActive(t *testing.T) {
+	cfg := &Config{}
+
+	if err := cfg.AddProfile("default", Profile{Host: "https://circleci.com", Endpoint: "graphql-unstable"}); err != nil {
+		t.Fatalf("AddProfile: %s", err)
+	}
+	if err := cfg.AddProfile("staging", Profile{Host: "https://staging.circleci.com", Endpoint: "graphql-unstable"}); err != nil {
+		t.Fatalf("AddProfile: %s", err)
+	}
+
+	if cfg.CurrentProfile != "default" {
+		t.Fatalf("expected adding a second profile to leave the active one alone, got %q", cfg.CurrentProfile)
+	}
+	if cfg.Host != "https://circleci.com" {
+		t.Fatalf("expected cfg's resolved fields to still be \"default\"'s, got %q", cfg.Host)
+	}
+	if len(cfg.Profiles) != 2 {
+		t.Fatalf("expected both profiles to be present, got %v", cfg.ListProfiles())
+	}
+}
+
+func TestAddProfileRejectsEmptyName(t *testing.T) {
+	cfg := &Config{}
+
+	if err := cfg.AddProfile("", Profile{Host: "https://circleci.com", Endpoint: "graphql-unstable"}); err == nil {
+		t.Fatal("expected an error for an empty profile name")
+	}
+}
+
+func TestUseProfileSwitchesResolvedFields(t *testing.T) {
+	cfg := &Config{}
+	if err := cfg.AddProfile("default", Profile{Host: "https://circleci.com", Endpoint: "graphql-unstable"}); err != nil {
+		t.Fatalf("AddProfile: %s", err)
+	}
+	if err := cfg.AddProfile("staging", Profile{Host: "https://staging.circleci.com", Endpoint: "graphql-unstable"}); err != nil {
+		t.Fatalf("AddProfile: %s", err)
+	}
+
+	if err := cfg.UseProfile("staging"); err != nil {
+		t.Fatalf("UseProfile: %s", err)
+	}
+	if cfg.CurrentProfile != "staging" || cfg.Host != "https://staging.circleci.com" {
+		t.Fatalf("expected cfg to resolve to \"staging\", got %+v", cfg)
+	}
+
+	if err := cfg.UseProfile("nonexistent"); err == nil {
+		t.Fatal("expected an error switching to an unconfigured profile")
+	}
+}
+
+func TestRemoveProfileGuardsActive(t *testing.T) {
+	cfg := &Config{}
+	if err := cfg.AddProfile("default", Profile{Host: "https://circleci.com", Endpoint: "graphql-unstable"}); err != nil {
+		t.Fatalf("AddProfile: %s", err)
+	}
+	if err := cfg.AddProfile("staging", Profile{Host: "https://staging.circleci.com", Endpoint: "graphql-unstable"}); err != nil {
+		t.Fatalf("AddProfile: %s", err)
+	}
+
+	if err := cfg.RemoveProfile("default"); err == nil {
+		t.Fatal("expected removing the active profile to be rejected")
+	}
+
+	if err := cfg.RemoveProfile("staging"); err != nil {
+		t.Fatalf("RemoveProfile: %s", err)
+	}
+	if _, ok := cfg.Profiles["staging"]; ok {
+		t.Fatal("expected \"staging\" to be removed")
+	}
+
+	if err := cfg.RemoveProfile("nonexistent"); err == nil {
+		t.Fatal("expected an error removing an unconfigured profile")
+	}
+}
+
+func TestMigrateLegacyProfile(t *testing.T) {
+	cfg := &Config{GitHubAPI: "https://api.github.com"}
+	legacy := legacyConfig{Host: "https://circleci.com", Endpoint: "graphql-unstable", Token: "legacytoken00000000000000"}
+
+	cfg.migrateLegacyProfile(legacy)
+
+	if cfg.CurrentProfile != defaultProfileName {
+		t.Fatalf("expected current profile %q, got %q", defaultProfileName, cfg.CurrentProfile)
+	}
+
+	profile, ok := cfg.Profiles[defaultProfileName]
+	if !ok {
+		t.Fatal("expected a \"default\" profile to be created")
+	}
+	if profile.Host != legacy.Host || profile.Endpoint != legacy.Endpoint || profile.Token != legacy.Token {
+		t.Fatalf("expected the legacy fields to be carried over, got %+v", profile)
+	}
+	if profile.GitHubAPI != "https://api.github.com" {
+		t.Fatalf("expected GitHubAPI to be preserved, got %q", profile.GitHubAPI)
+	}
+}
+
+func TestMigrateLegacyProfileSkipsWhenProfilesAlreadyPresent(t *testing.T) {
+	cfg := &Config{
+		CurrentProfile: "staging",
+		Profiles: map[string]*Profile{
+			"staging": {Host: "https://staging.circleci.com", Endpoint: "graphql-unstable"},
+		},
+	}
+
+	cfg.migrateLegacyProfile(legacyConfig{Host: "https://circleci.com", Endpoint: "graphql-unstable", Token: "legacytoken00000000000000"})
+
+	if len(cfg.Profiles) != 1 {
+		t.Fatalf("expected migration to be skipped when profiles already exist, got %v", cfg.ListProfiles())
+	}
+	if cfg.CurrentProfile != "staging" {
+		t.Fatalf("expected current profile to remain \"staging\", got %q", cfg.CurrentProfile)
+	}
+}
+
+func TestMigrateLegacyProfileSkipsWhenLegacyEmpty(t *testing.T) {
+	cfg := &Config{}
+
+	cfg.migrateLegacyProfile(legacyConfig{})
+
+	if len(cfg.Profiles) != 0 {
+		t.Fatalf("expected no profile to be created from an empty legacy config, got %v", cfg.ListProfiles())
+	}
+}
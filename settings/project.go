@@ -0,0 +1,98 @@
+package settings
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// projectConfigPath is the per-project override file, looked up relative
+// to each directory walked by findProjectConfig.
+const projectConfigPath = ".circleci/cli.yml"
+
+// projectOverride is the subset of Config a per-project .circleci/cli.yml
+// may override. Token is deliberately excluded: a project file is
+// typically checked into source control alongside the rest of the repo.
+type projectOverride struct {
+	Host     string `yaml:"host"`
+	Endpoint string `yaml:"endpoint"`
+}
+
+// mergeProjectConfig walks from the working directory upward looking for
+// a .circleci/cli.yml, stopping at the first one found or the filesystem
+// root, and layers any host/endpoint it sets on top of cfg. This mirrors
+// how kubectl, docker-compose, and various linters resolve nearest-
+// ancestor config, letting teams pin a self-hosted CircleCI server
+// install per repo.
+func (cfg *Config) mergeProjectConfig() error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	path, ok := findProjectConfig(wd)
+	if !ok {
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(path) // #nosec
+	if err != nil {
+		return err
+	}
+
+	var override projectOverride
+	if err := yaml.Unmarshal(content, &override); err != nil {
+		return wrapYAMLError(path, err)
+	}
+
+	if override.Host == "" && override.Endpoint == "" {
+		return nil
+	}
+
+	// Snapshot both the global profile's values before overlaying the
+	// project's, and the overlaid values themselves, so syncActiveProfile
+	// can tell a field the caller left untouched (still equal to the
+	// overlay) from one it explicitly reassigned after Load, and only
+	// restore the former.
+	cfg.preProjectProfile = &Profile{Host: cfg.Host, Endpoint: cfg.Endpoint}
+
+	if override.Host != "" {
+		cfg.Host = override.Host
+	}
+	if override.Endpoint != "" {
+		cfg.Endpoint = override.Endpoint
+	}
+
+	cfg.projectOverlay = &Profile{Host: cfg.Host, Endpoint: cfg.Endpoint}
+
+	cfg.sources = append(cfg.sources, path)
+	return nil
+}
+
+// findProjectConfig walks from dir upward looking for a .circleci/cli.yml,
+// returning the first one found and stopping at the filesystem root.
+func findProjectConfig(dir string) (string, bool) {
+	for {
+		candidate := filepath.Join(dir, projectConfigPath)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, true
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// Sources returns the settings files that contributed to this Config's
+// values, in merge order: the user-global cli.yml first, then any
+// per-project .circleci/cli.yml that overrode it, then "environment
+// variables" if any were applied. Used by `circleci config debug` to show
+// exactly which file supplied each value.
+func (cfg *Config) Sources() []string {
+	return cfg.sources
+}
@@ -0,0 +1,149 @@
+package settings
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// chdir switches to dir for the duration of the test, restoring the
+// original working directory on cleanup.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.Chdir(wd) // nolint: errcheck
+	})
+}
+
+// newGlobalConfig writes a one-profile cli.yml to settingsDir, as if the
+// user had already run `circleci setup`.
+func newGlobalConfig(t *testing.T, settingsDir, host, endpoint, token string) {
+	t.Helper()
+
+	cfg := NewConfig(WithSettingsPath(settingsDir))
+	if err := cfg.LoadFromDisk(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.AddProfile("default", Profile{Host: host, Endpoint: endpoint, Token: token}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.WriteToDisk(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadMergesProjectOverride(t *testing.T) {
+	settingsDir, err := ioutil.TempDir("", "circleci-cli-global")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(settingsDir) // nolint: errcheck
+
+	newGlobalConfig(t, settingsDir, "https://circleci.com", "graphql-unstable", "globaltoken0000000000000")
+
+	projectDir, err := ioutil.TempDir("", "circleci-cli-project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(projectDir) // nolint: errcheck
+
+	projectConfig := filepath.Join(projectDir, ".circleci", "cli.yml")
+	if err := os.MkdirAll(filepath.Dir(projectConfig), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(projectConfig, []byte("host: https://circleci.example.internal\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	chdir(t, projectDir)
+
+	cfg := NewConfig(WithSettingsPath(settingsDir))
+	if err := cfg.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Host != "https://circleci.example.internal" {
+		t.Fatalf("expected project host override, got %q", cfg.Host)
+	}
+	if cfg.Endpoint != "graphql-unstable" {
+		t.Fatalf("expected global endpoint to be unaffected, got %q", cfg.Endpoint)
+	}
+
+	sources := cfg.Sources()
+	if len(sources) != 2 || sources[0] != filepath.Join(settingsDir, "cli.yml") || sources[1] != projectConfig {
+		t.Fatalf("unexpected Sources(): %v", sources)
+	}
+
+	// A later WriteToDisk (e.g. a token-store migration) must never
+	// persist the project's host into the user-global cli.yml.
+	if err := cfg.WriteToDisk(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := NewConfig(WithSettingsPath(settingsDir))
+	if err := reloaded.LoadFromDisk(); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Host != "https://circleci.com" {
+		t.Fatalf("project override leaked into the global cli.yml: got host %q", reloaded.Host)
+	}
+}
+
+func TestWriteToDiskKeepsExplicitReassignmentAfterProjectOverride(t *testing.T) {
+	settingsDir, err := ioutil.TempDir("", "circleci-cli-global")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(settingsDir) // nolint: errcheck
+
+	newGlobalConfig(t, settingsDir, "https://circleci.com", "graphql-unstable", "globaltoken0000000000000")
+
+	projectDir, err := ioutil.TempDir("", "circleci-cli-project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(projectDir) // nolint: errcheck
+
+	projectConfig := filepath.Join(projectDir, ".circleci", "cli.yml")
+	if err := os.MkdirAll(filepath.Dir(projectConfig), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(projectConfig, []byte("host: https://circleci.example.internal\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	chdir(t, projectDir)
+
+	cfg := NewConfig(WithSettingsPath(settingsDir))
+	if err := cfg.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a `circleci setup` run from inside the project directory
+	// explicitly pointing the global config at a new host, rather than
+	// just reading the merged, project-overridden one.
+	cfg.Host = "https://new-global-host.example.com"
+	cfg.Token = "newtoken0000000000000000"
+
+	if err := cfg.WriteToDisk(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := NewConfig(WithSettingsPath(settingsDir))
+	if err := reloaded.LoadFromDisk(); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Host != "https://new-global-host.example.com" {
+		t.Fatalf("explicit Host reassignment after Load was dropped: got %q", reloaded.Host)
+	}
+	if reloaded.Token != "newtoken0000000000000000" {
+		t.Fatalf("expected the new token to be persisted, got %q", reloaded.Token)
+	}
+}
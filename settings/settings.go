@@ -1,38 +1,116 @@
 package settings
 
 import (
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
-	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"time"
 
-	yaml "gopkg.in/yaml.v2"
+	yaml "gopkg.in/yaml.v3"
 )
 
+// defaultProfileName is the profile a legacy, single-profile cli.yml is
+// migrated into, and the profile selected when none is configured.
+const defaultProfileName = "default"
+
+// Profile holds the connection settings for a single named CircleCI
+// profile, analogous to an AWS CLI profile or a kubeconfig context.
+type Profile struct {
+	Host      string `yaml:"host"`
+	Endpoint  string `yaml:"endpoint"`
+	Token     string `yaml:"token"`
+	GitHubAPI string `yaml:"github_api,omitempty"`
+}
+
+// legacyConfig captures the pre-profile cli.yml schema, where host,
+// endpoint, and token lived at the top level, so LoadFromDisk can detect
+// and migrate it.
+type legacyConfig struct {
+	Host     string
+	Endpoint string
+	Token    string
+}
+
 // Config is used to represent the current state of a CLI instance.
 type Config struct {
+	// SchemaVersion records which on-disk shape this Config was last
+	// written as, so migrateSchema knows what to upgrade. See
+	// currentSchemaVersion.
+	SchemaVersion int `yaml:"schema_version,omitempty"`
+	// CurrentProfile is the name of the active entry in Profiles. Host,
+	// Endpoint, Token, and GitHubAPI below are resolved from it.
+	CurrentProfile string              `yaml:"current_profile,omitempty"`
+	Profiles       map[string]*Profile `yaml:"profiles,omitempty"`
+	// TokenStoreKind selects where profile tokens are persisted: "file"
+	// (the default, inline in this YAML document) or "keyring" (the OS
+	// keyring, via TokenStore). See MigrateTokenStore.
+	TokenStoreKind  string `yaml:"token_store,omitempty"`
 	GitHubAPI       string `yaml:"-"`
-	Host            string
-	Endpoint        string
-	Token           string
+	Host            string `yaml:"-"`
+	Endpoint        string `yaml:"-"`
+	Token           string `yaml:"-"`
 	Debug           bool   `yaml:"-"`
 	Address         string `yaml:"-"`
 	FileUsed        string `yaml:"-"`
 	SkipUpdateCheck bool   `yaml:"-"`
+	// SettingsPath is an explicit override for the directory the CLI reads
+	// and writes its settings to. When empty, the path is resolved via
+	// resolveSettingsPath.
+	SettingsPath string `yaml:"-"`
+	// sources records, in merge order, what supplied this Config's values:
+	// the user-global cli.yml, any per-project override file, and whether
+	// environment variables applied on top. See Sources.
+	sources []string
+	// preProjectProfile holds the active profile's Host and Endpoint as
+	// they were before mergeProjectConfig overlaid a per-project override.
+	// projectOverlay holds the values mergeProjectConfig set them to.
+	// syncActiveProfile restores a field from preProjectProfile only when
+	// it still matches projectOverlay, i.e. the caller hasn't explicitly
+	// reassigned it since Load. Both are nil when no project override was
+	// applied.
+	preProjectProfile *Profile
+	projectOverlay    *Profile
+}
+
+// Option configures a Config before it is used to load or write settings.
+type Option func(*Config)
+
+// WithSettingsPath overrides the directory the CLI reads and writes its
+// settings to, taking precedence over $CIRCLECI_CLI_CONFIG_DIR,
+// $XDG_CONFIG_HOME, and the default ~/.circleci.
+func WithSettingsPath(path string) Option {
+	return func(cfg *Config) {
+		cfg.SettingsPath = path
+	}
+}
+
+// NewConfig returns a Config with the given options applied.
+func NewConfig(opts ...Option) *Config {
+	cfg := &Config{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
 }
 
 // UpdateCheck is used to represent settings for checking for updates of the CLI.
 type UpdateCheck struct {
+	SchemaVersion   int       `yaml:"schema_version,omitempty"`
 	LastUpdateCheck time.Time `yaml:"last_update_check"`
 	FileUsed        string    `yaml:"-"`
+	// SettingsPath is an explicit override for the directory the update
+	// check file is read from and written to. See Config.SettingsPath.
+	SettingsPath string `yaml:"-"`
 }
 
 // Load will read the update check settings from the user's disk and then deserialize it into the current instance.
 func (upd *UpdateCheck) Load() error {
-	path := filepath.Join(settingsPath(), updateCheckFilename())
+	path := filepath.Join(resolveSettingsPath(upd.SettingsPath), updateCheckFilename())
 
 	if err := ensureSettingsFileExists(path); err != nil {
 		return err
@@ -40,13 +118,19 @@ func (upd *UpdateCheck) Load() error {
 
 	upd.FileUsed = path
 
-	content, err := ioutil.ReadFile(path) // #nosec
-	if err != nil {
-		return err
-	}
+	return withFileLock(path, false, func() error {
+		content, err := ioutil.ReadFile(path) // #nosec
+		if err != nil {
+			return err
+		}
 
-	err = yaml.Unmarshal(content, &upd)
-	return err
+		if err := yaml.Unmarshal(content, &upd); err != nil {
+			return wrapYAMLError(path, err)
+		}
+
+		upd.SchemaVersion = currentSchemaVersion
+		return nil
+	})
 }
 
 // WriteToDisk will write the last update check to disk by serializing the YAML
@@ -56,24 +140,35 @@ func (upd *UpdateCheck) WriteToDisk() error {
 		return err
 	}
 
-	err = ioutil.WriteFile(upd.FileUsed, enc, 0600)
-	return err
+	return withFileLock(upd.FileUsed, true, func() error {
+		return writeFileAtomic(upd.FileUsed, enc, 0600)
+	})
 }
 
-// Load will read the config from the user's disk and then evaluate possible configuration from the environment.
+// Load will read the config from the user's disk, merge in any
+// per-project override, and then evaluate possible configuration from the
+// environment. Later sources win: project overrides beat the user-global
+// file, and environment variables beat both. See Sources for the result.
 func (cfg *Config) Load() error {
 	if err := cfg.LoadFromDisk(); err != nil {
 		return err
 	}
+	cfg.sources = []string{cfg.FileUsed}
+
+	if err := cfg.mergeProjectConfig(); err != nil {
+		return err
+	}
 
-	cfg.LoadFromEnv("circleci_cli")
+	if cfg.LoadFromEnv("circleci_cli") {
+		cfg.sources = append(cfg.sources, "environment variables")
+	}
 
 	return nil
 }
 
 // LoadFromDisk is used to read config from the user's disk and deserialize the YAML into our runtime config.
 func (cfg *Config) LoadFromDisk() error {
-	path := filepath.Join(settingsPath(), configFilename())
+	path := filepath.Join(cfg.settingsPath(), cfg.configFilename())
 
 	if err := ensureSettingsFileExists(path); err != nil {
 		return err
@@ -81,39 +176,264 @@ func (cfg *Config) LoadFromDisk() error {
 
 	cfg.FileUsed = path
 
-	content, err := ioutil.ReadFile(path) // #nosec
-	if err != nil {
-		return err
-	}
+	return withFileLock(path, false, func() error {
+		content, err := ioutil.ReadFile(path) // #nosec
+		if err != nil {
+			return err
+		}
 
-	err = yaml.Unmarshal(content, &cfg)
-	return err
+		var legacy legacyConfig
+		if err := yaml.Unmarshal(content, &legacy); err != nil {
+			return wrapYAMLError(path, err)
+		}
+
+		if err := yaml.Unmarshal(content, &cfg); err != nil {
+			return wrapYAMLError(path, err)
+		}
+
+		cfg.migrateLegacyProfile(legacy)
+		cfg.migrateSchema()
+
+		if err := cfg.selectActiveProfile(); err != nil {
+			return err
+		}
+
+		return cfg.Validate()
+	})
 }
 
 // WriteToDisk will write the runtime config instance to disk by serializing the YAML
 func (cfg *Config) WriteToDisk() error {
+	if err := cfg.syncActiveProfile(); err != nil {
+		return err
+	}
+
 	enc, err := yaml.Marshal(&cfg)
 	if err != nil {
 		return err
 	}
 
-	err = ioutil.WriteFile(cfg.FileUsed, enc, 0600)
-	return err
+	return withFileLock(cfg.FileUsed, true, func() error {
+		return writeFileAtomic(cfg.FileUsed, enc, 0600)
+	})
 }
 
-// LoadFromEnv will read from environment variables of the given prefix for host, endpoint, and token specifically.
-func (cfg *Config) LoadFromEnv(prefix string) {
+// LoadFromEnv reads environment variables of the given prefix for
+// profile, host, endpoint, and token specifically, overriding any value
+// already on cfg. It reports whether anything was applied.
+func (cfg *Config) LoadFromEnv(prefix string) bool {
+	applied := false
+
+	if profile := ReadFromEnv(prefix, "profile"); profile != "" {
+		// Best-effort: an unknown profile name is left for UseProfile to
+		// report when the caller next needs it explicitly.
+		if err := cfg.UseProfile(profile); err == nil {
+			applied = true
+		}
+	}
+
 	if host := ReadFromEnv(prefix, "host"); host != "" {
 		cfg.Host = host
+		applied = true
 	}
 
 	if endpoint := ReadFromEnv(prefix, "endpoint"); endpoint != "" {
 		cfg.Endpoint = endpoint
+		applied = true
 	}
 
 	if token := ReadFromEnv(prefix, "token"); token != "" {
 		cfg.Token = token
+		applied = true
+	}
+
+	return applied
+}
+
+// UseProfile selects the named profile as active, resolving Host, Endpoint,
+// Token, and GitHubAPI from it for the rest of the CLI to consume.
+func (cfg *Config) UseProfile(name string) error {
+	profile, ok := cfg.Profiles[name]
+	if !ok {
+		return fmt.Errorf("no such profile: %s", name)
+	}
+
+	cfg.CurrentProfile = name
+	cfg.applyProfile(profile)
+
+	token, err := cfg.tokenStore().Get(name)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		cfg.Token = token
+	}
+
+	return nil
+}
+
+// ListProfiles returns the names of all configured profiles, sorted
+// alphabetically.
+func (cfg *Config) ListProfiles() []string {
+	names := make([]string, 0, len(cfg.Profiles))
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AddProfile creates or updates a named profile. The first profile added
+// to a Config becomes the active one.
+func (cfg *Config) AddProfile(name string, profile Profile) error {
+	if name == "" {
+		return errors.New("profile name must not be empty")
+	}
+
+	if cfg.Profiles == nil {
+		cfg.Profiles = map[string]*Profile{}
+	}
+	cfg.Profiles[name] = &profile
+
+	if cfg.CurrentProfile == "" {
+		return cfg.UseProfile(name)
+	}
+
+	if cfg.CurrentProfile == name {
+		cfg.applyProfile(&profile)
+	}
+
+	return nil
+}
+
+// RemoveProfile deletes a named profile. The active profile cannot be
+// removed; switch to a different one first.
+func (cfg *Config) RemoveProfile(name string) error {
+	if _, ok := cfg.Profiles[name]; !ok {
+		return fmt.Errorf("no such profile: %s", name)
+	}
+
+	if cfg.CurrentProfile == name {
+		return fmt.Errorf("cannot remove active profile %q: switch profiles first", name)
+	}
+
+	delete(cfg.Profiles, name)
+	return nil
+}
+
+// applyProfile copies a profile's settings onto the Config's resolved
+// fields.
+func (cfg *Config) applyProfile(profile *Profile) {
+	cfg.Host = profile.Host
+	cfg.Endpoint = profile.Endpoint
+	cfg.Token = profile.Token
+	cfg.GitHubAPI = profile.GitHubAPI
+}
+
+// migrateLegacyProfile upgrades a pre-profile cli.yml, which stored host,
+// endpoint, and token at the top level, into a single profile named
+// "default".
+func (cfg *Config) migrateLegacyProfile(legacy legacyConfig) {
+	if len(cfg.Profiles) > 0 {
+		return
+	}
+
+	if legacy.Host == "" && legacy.Endpoint == "" && legacy.Token == "" {
+		return
+	}
+
+	cfg.Profiles = map[string]*Profile{
+		defaultProfileName: {
+			Host:      legacy.Host,
+			Endpoint:  legacy.Endpoint,
+			Token:     legacy.Token,
+			GitHubAPI: cfg.GitHubAPI,
+		},
+	}
+	cfg.CurrentProfile = defaultProfileName
+}
+
+// selectActiveProfile resolves Host, Endpoint, Token, and GitHubAPI from
+// CurrentProfile (defaulting to "default") once Profiles has been loaded.
+func (cfg *Config) selectActiveProfile() error {
+	if len(cfg.Profiles) == 0 {
+		return nil
+	}
+
+	name := cfg.CurrentProfile
+	if name == "" {
+		name = defaultProfileName
+	}
+
+	profile, ok := cfg.Profiles[name]
+	if !ok {
+		return fmt.Errorf("current profile %q is not configured", name)
+	}
+
+	cfg.CurrentProfile = name
+	cfg.applyProfile(profile)
+
+	token, err := cfg.tokenStore().Get(cfg.CurrentProfile)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		cfg.Token = token
 	}
+
+	return nil
+}
+
+// syncActiveProfile writes the Config's resolved Host, Endpoint, Token, and
+// GitHubAPI fields back into Profiles[CurrentProfile] before it is
+// serialized, picking up any changes made directly to those fields, and
+// migrates a Config with no Profiles yet onto the "default" profile. The
+// token is routed through the active TokenStore rather than written
+// inline when that store doesn't want it to be. If a per-project
+// .circleci/cli.yml overlaid Host/Endpoint (see mergeProjectConfig) and
+// the caller hasn't reassigned them since, the global profile's
+// pre-override values are written instead, so a project override is
+// never baked into the user-global cli.yml; an explicit reassignment
+// after Load (e.g. a `circleci setup` run) always wins.
+func (cfg *Config) syncActiveProfile() error {
+	if cfg.CurrentProfile == "" {
+		if cfg.Host == "" && cfg.Endpoint == "" && cfg.Token == "" && cfg.GitHubAPI == "" {
+			return nil
+		}
+		cfg.CurrentProfile = defaultProfileName
+	}
+
+	if cfg.Profiles == nil {
+		cfg.Profiles = map[string]*Profile{}
+	}
+
+	store := cfg.tokenStore()
+	if err := store.Set(cfg.CurrentProfile, cfg.Token); err != nil {
+		return err
+	}
+
+	inlineToken := cfg.Token
+	if !store.Inline() {
+		inlineToken = ""
+	}
+
+	host, endpoint := cfg.Host, cfg.Endpoint
+	if cfg.preProjectProfile != nil && cfg.projectOverlay != nil {
+		if cfg.Host == cfg.projectOverlay.Host {
+			host = cfg.preProjectProfile.Host
+		}
+		if cfg.Endpoint == cfg.projectOverlay.Endpoint {
+			endpoint = cfg.preProjectProfile.Endpoint
+		}
+	}
+
+	cfg.Profiles[cfg.CurrentProfile] = &Profile{
+		Host:      host,
+		Endpoint:  endpoint,
+		Token:     inlineToken,
+		GitHubAPI: cfg.GitHubAPI,
+	}
+	return nil
 }
 
 // ReadFromEnv takes a prefix and field to search the environment for after capitalizing and joining them with an underscore.
@@ -140,21 +460,50 @@ func updateCheckFilename() string {
 }
 
 // configFilename returns the name of the cli config file
-func configFilename() string {
-	// TODO: Make this configurable
+func (cfg *Config) configFilename() string {
 	return "cli.yml"
 }
 
-// settingsPath returns the path of the CLI settings directory
-func settingsPath() string {
-	// TODO: Make this configurable
-	return path.Join(UserHomeDir(), ".circleci")
+// settingsPath returns the path of the CLI settings directory, honoring
+// cfg.SettingsPath as an override. See resolveSettingsPath for the full
+// lookup chain.
+func (cfg *Config) settingsPath() string {
+	return resolveSettingsPath(cfg.SettingsPath)
 }
 
-// ensureSettingsFileExists does just that.
-func ensureSettingsFileExists(path string) error {
-	// TODO - handle invalid YAML config files.
+// resolveSettingsPath determines the directory the CLI should read and
+// write its settings to. The lookup chain, in order of precedence, is:
+//
+//  1. override, when non-empty (typically Config.SettingsPath or
+//     UpdateCheck.SettingsPath, set via WithSettingsPath)
+//  2. $CIRCLECI_CLI_CONFIG_DIR
+//  3. on Windows, os.UserConfigDir() (%APPDATA%) joined with "circleci";
+//     on other platforms, $XDG_CONFIG_HOME/circleci when $XDG_CONFIG_HOME
+//     is set
+//  4. ~/.circleci
+func resolveSettingsPath(override string) string {
+	if override != "" {
+		return override
+	}
+
+	if dir := os.Getenv("CIRCLECI_CLI_CONFIG_DIR"); dir != "" {
+		return dir
+	}
+
+	if runtime.GOOS == "windows" {
+		if configHome, err := os.UserConfigDir(); err == nil && configHome != "" {
+			return filepath.Join(configHome, "circleci")
+		}
+	} else if xdgConfigHome := os.Getenv("XDG_CONFIG_HOME"); xdgConfigHome != "" {
+		return filepath.Join(xdgConfigHome, "circleci")
+	}
+
+	return filepath.Join(UserHomeDir(), ".circleci")
+}
 
+// ensureSettingsFileExists does just that. Malformed YAML already on disk
+// is caught later, by LoadFromDisk's yaml.Unmarshal and Validate.
+func ensureSettingsFileExists(path string) error {
 	_, err := os.Stat(path)
 
 	if err == nil {
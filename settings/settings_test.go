@@ -0,0 +1,78 @@
+package settings
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// TestConfigWriteToDiskConcurrent exercises the atomic-write and
+// file-locking guarantees added for concurrent `circleci` invocations: N
+// goroutines race to load, mutate, and save the same cli.yml, and the
+// file must always be left as a single, complete, valid YAML document,
+// never corrupted or truncated by an interleaved write.
+func TestConfigWriteToDiskConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "circleci-cli-settings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	const n = 20
+
+	var wg sync.WaitGroup
+	wg.Add(n)
+
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+
+			cfg := NewConfig(WithSettingsPath(dir))
+			if err := cfg.LoadFromDisk(); err != nil {
+				t.Errorf("LoadFromDisk: %s", err)
+				return
+			}
+
+			if err := cfg.AddProfile("default", Profile{
+				Host:     "https://circleci.com",
+				Endpoint: "graphql-unstable",
+				Token:    "deadbeefdeadbeefdeadbeef00",
+			}); err != nil {
+				t.Errorf("AddProfile: %s", err)
+				return
+			}
+
+			if err := cfg.WriteToDisk(); err != nil {
+				t.Errorf("WriteToDisk: %s", err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "cli.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg Config
+	if err := yaml.Unmarshal(content, &cfg); err != nil {
+		t.Fatalf("cli.yml was left as invalid YAML after concurrent writes: %s", err)
+	}
+
+	if cfg.CurrentProfile != "default" {
+		t.Fatalf("expected current_profile %q, got %q", "default", cfg.CurrentProfile)
+	}
+
+	profile, ok := cfg.Profiles["default"]
+	if !ok {
+		t.Fatal("expected a \"default\" profile to be present")
+	}
+	if profile.Host != "https://circleci.com" || profile.Endpoint != "graphql-unstable" {
+		t.Fatalf("profile was left incomplete: %+v", profile)
+	}
+}
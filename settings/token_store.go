@@ -0,0 +1,149 @@
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/zalando/go-keyring"
+)
+
+// Token store kinds, persisted at "token_store" in cli.yml.
+const (
+	tokenStoreFile    = "file"
+	tokenStoreKeyring = "keyring"
+)
+
+// keyringService namespaces the CLI's entries within the OS keyring.
+const keyringService = "circleci-cli"
+
+// TokenStore persists and retrieves a profile's CircleCI API token,
+// decoupling Config from where the token actually lives.
+type TokenStore interface {
+	// Get returns the token stored for profile, or an empty string if this
+	// store has none for it.
+	Get(profile string) (string, error)
+	// Set stores token for profile.
+	Set(profile, token string) error
+	// Delete removes any token stored for profile.
+	Delete(profile string) error
+	// Inline reports whether the token should additionally be persisted in
+	// cli.yml alongside the rest of the profile.
+	Inline() bool
+}
+
+// fileTokenStore is the default TokenStore: the token travels with the
+// rest of the profile in cli.yml, as it always has.
+type fileTokenStore struct{}
+
+func (fileTokenStore) Get(profile string) (string, error) { return "", nil }
+func (fileTokenStore) Set(profile, token string) error    { return nil }
+func (fileTokenStore) Delete(profile string) error        { return nil }
+func (fileTokenStore) Inline() bool                       { return true }
+
+// keyringTokenStore persists tokens in the OS keychain (macOS Keychain,
+// Windows Credential Manager, or libsecret on Linux) via go-keyring,
+// rather than as plaintext in cli.yml.
+type keyringTokenStore struct{}
+
+func (k keyringTokenStore) Get(profile string) (string, error) {
+	token, err := keyring.Get(keyringService, profile)
+	switch {
+	case err == nil:
+		return token, nil
+
+	case errors.Is(err, keyring.ErrNotFound):
+		return "", nil
+
+	case isBackendUnavailable(err):
+		// Many environments this CLI runs in (bare CI containers, in
+		// particular) have no OS keyring/secret-service backend at all.
+		// Treat that the same as "no token stored" rather than failing
+		// every Config.Load, but say so: unlike ErrNotFound, this means
+		// the keyring was never consulted.
+		fmt.Fprintf(os.Stderr, "warning: no OS keyring backend available (%s); treating token as unset\n", err)
+		return "", nil
+
+	default:
+		// A backend exists but refused the read - e.g. the keyring is
+		// locked, access was denied, or the stored entry is corrupted.
+		// Surfacing this lets the user fix it instead of silently
+		// appearing logged out.
+		return "", fmt.Errorf("reading token from keyring: %w", err)
+	}
+}
+
+func (k keyringTokenStore) Set(profile, token string) error {
+	if token == "" {
+		return k.Delete(profile)
+	}
+	return keyring.Set(keyringService, profile, token)
+}
+
+func (k keyringTokenStore) Delete(profile string) error {
+	// Best-effort: there may have been nothing to delete, or no keyring
+	// backend available at all. Either way the caller should keep going.
+	_ = keyring.Delete(keyringService, profile)
+	return nil
+}
+
+func (keyringTokenStore) Inline() bool { return false }
+
+// isBackendUnavailable reports whether err looks like there is no OS
+// keyring/secret-service backend to talk to at all, as opposed to a real
+// failure (locked keyring, permission denied, corrupted entry) against a
+// backend that does exist. go-keyring only has a typed sentinel for this
+// on unsupported platforms; on Linux, "no D-Bus secret service running"
+// (the common case in bare CI containers) surfaces as a plain dbus
+// connection error, so this falls back to matching on its message.
+func isBackendUnavailable(err error) bool {
+	if errors.Is(err, keyring.ErrUnsupportedPlatform) {
+		return true
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, substr := range []string{
+		"org.freedesktop.secrets",
+		"dbus_session_bus_address",
+		"no such file or directory",
+		"connection refused",
+	} {
+		if strings.Contains(msg, substr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tokenStore returns the TokenStore implementation selected by
+// cfg.TokenStoreKind, defaulting to the plaintext file store.
+func (cfg *Config) tokenStore() TokenStore {
+	if cfg.TokenStoreKind == tokenStoreKeyring {
+		return keyringTokenStore{}
+	}
+	return fileTokenStore{}
+}
+
+// MigrateTokenStore switches the active profile's token storage to kind
+// ("file" or "keyring"), moving the token currently held by the old store
+// into the new one. It is the basis for `circleci setup --token-store`.
+func (cfg *Config) MigrateTokenStore(kind string) error {
+	if kind != tokenStoreFile && kind != tokenStoreKeyring {
+		return fmt.Errorf("unknown token store: %s", kind)
+	}
+
+	if kind == cfg.TokenStoreKind {
+		return nil
+	}
+
+	previousStore := cfg.tokenStore()
+	cfg.TokenStoreKind = kind
+
+	if err := cfg.tokenStore().Set(cfg.CurrentProfile, cfg.Token); err != nil {
+		return err
+	}
+
+	return previousStore.Delete(cfg.CurrentProfile)
+}
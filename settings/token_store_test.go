@@ -0,0 +1,65 @@
+package settings
+
+import (
+	"testing"
+
+	"github.com/zalando/go-keyring"
+)
+
+// TestKeyringTokenStoreRoundTrip exercises keyringTokenStore against
+// go-keyring's in-memory mock backend: set, get, clear-by-empty-Set (see
+// chunk0-3), and delete must all round-trip without touching cli.yml.
+func TestKeyringTokenStoreRoundTrip(t *testing.T) {
+	keyring.MockInit()
+
+	store := keyringTokenStore{}
+
+	if token, err := store.Get("default"); err != nil || token != "" {
+		t.Fatalf("expected no token before Set, got (%q, %v)", token, err)
+	}
+
+	if err := store.Set("default", "sometoken0000000000000000"); err != nil {
+		t.Fatalf("Set: %s", err)
+	}
+
+	token, err := store.Get("default")
+	if err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+	if token != "sometoken0000000000000000" {
+		t.Fatalf("expected stored token back, got %q", token)
+	}
+
+	// Setting an empty token must delete the entry, not leave it stale.
+	if err := store.Set("default", ""); err != nil {
+		t.Fatalf("Set(\"\"): %s", err)
+	}
+	if token, err := store.Get("default"); err != nil || token != "" {
+		t.Fatalf("expected token cleared after Set(\"\"), got (%q, %v)", token, err)
+	}
+
+	if err := store.Set("default", "anothertoken000000000000"); err != nil {
+		t.Fatalf("Set: %s", err)
+	}
+	if err := store.Delete("default"); err != nil {
+		t.Fatalf("Delete: %s", err)
+	}
+	if token, err := store.Get("default"); err != nil || token != "" {
+		t.Fatalf("expected token cleared after Delete, got (%q, %v)", token, err)
+	}
+}
+
+// TestKeyringTokenStoreGetNotFound confirms a profile that was never
+// stored is reported as simply unset, not an error.
+func TestKeyringTokenStoreGetNotFound(t *testing.T) {
+	keyring.MockInit()
+
+	store := keyringTokenStore{}
+	token, err := store.Get("never-set")
+	if err != nil {
+		t.Fatalf("expected no error for an unset profile, got %s", err)
+	}
+	if token != "" {
+		t.Fatalf("expected empty token, got %q", token)
+	}
+}
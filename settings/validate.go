@@ -0,0 +1,107 @@
+package settings
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// currentSchemaVersion is written to every cli.yml and update_check.yml on
+// save. Bump it and extend migrateSchema whenever the on-disk shape of
+// Config changes in a way older CLI versions can't read directly.
+const currentSchemaVersion = 1
+
+// minTokenLength is a loose sanity check on a CircleCI API token's shape,
+// not a full format validation.
+const minTokenLength = 10
+
+// ValidationError reports a settings file that failed schema validation,
+// naming the file and, where possible, the offending key.
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// wrapYAMLError turns a yaml.v3 decoding error into a *ValidationError
+// naming path, pulling out yaml.TypeError's per-field line information
+// when available instead of surfacing the raw yaml error.
+func wrapYAMLError(path string, err error) error {
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		msg := ""
+		for i, e := range typeErr.Errors {
+			if i > 0 {
+				msg += "; "
+			}
+			msg += e
+		}
+		return &ValidationError{Path: path, Message: msg}
+	}
+
+	return &ValidationError{Path: path, Message: err.Error()}
+}
+
+// migrateSchema brings cfg up to currentSchemaVersion, applying whatever
+// transformations are needed for the version it was loaded as. There is
+// only one version today: configs predating schema_version entirely load
+// as version 0, and are already normalized by migrateLegacyProfile, so
+// there is nothing left to do for them here. Future versions add cases
+// here rather than branching elsewhere in the package.
+func (cfg *Config) migrateSchema() {
+	cfg.SchemaVersion = currentSchemaVersion
+}
+
+// Validate checks that the active profile has well-formed required
+// fields, returning a *ValidationError naming the offending key if not.
+func (cfg *Config) Validate() error {
+	if cfg.CurrentProfile == "" {
+		return nil
+	}
+
+	profile, ok := cfg.Profiles[cfg.CurrentProfile]
+	if !ok {
+		return &ValidationError{
+			Path:    cfg.FileUsed,
+			Message: fmt.Sprintf("current_profile %q has no matching entry under profiles", cfg.CurrentProfile),
+		}
+	}
+
+	if err := profile.validate(); err != nil {
+		return &ValidationError{
+			Path:    cfg.FileUsed,
+			Message: fmt.Sprintf("profiles.%s: %s", cfg.CurrentProfile, err),
+		}
+	}
+
+	return nil
+}
+
+// validate checks that a profile's required fields are present and
+// well-formed: Host must be a well-formed absolute URL, Endpoint must be
+// set, and Token, when present inline, must look like a real token.
+func (p *Profile) validate() error {
+	if p.Host == "" {
+		return errors.New("host must not be empty")
+	}
+
+	u, err := url.Parse(p.Host)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("host %q is not a well-formed URL", p.Host)
+	}
+
+	if p.Endpoint == "" {
+		return errors.New("endpoint must not be empty")
+	}
+
+	if p.Token != "" && len(p.Token) < minTokenLength {
+		return errors.New("token does not look like a valid CircleCI API token")
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package settings
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestProfileValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile Profile
+		wantErr string
+	}{
+		{
+			name:    "valid",
+			profile: Profile{Host: "https://circleci.com", Endpoint: "graphql-unstable", Token: "sometoken0000000000000000"},
+		},
+		{
+			name:    "valid without a token",
+			profile: Profile{Host: "https://circleci.com", Endpoint: "graphql-unstable"},
+		},
+		{
+			name:    "missing host",
+			profile: Profile{Endpoint: "graphql-unstable"},
+			wantErr: "host must not be empty",
+		},
+		{
+			name:    "malformed host",
+			profile: Profile{Host: "not-a-url", Endpoint: "graphql-unstable"},
+			wantErr: `host "not-a-url" is not a well-formed URL`,
+		},
+		{
+			name:    "missing endpoint",
+			profile: Profile{Host: "https://circleci.com"},
+			wantErr: "endpoint must not be empty",
+		},
+		{
+			name:    "short token",
+			profile: Profile{Host: "https://circleci.com", Endpoint: "graphql-unstable", Token: "short"},
+			wantErr: "token does not look like a valid CircleCI API token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.profile.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got %s", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg := &Config{FileUsed: "/tmp/cli.yml"}
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("expected no error with no active profile, got %s", err)
+	}
+
+	cfg.CurrentProfile = "default"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected an error when current_profile has no matching entry")
+	} else {
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+			t.Fatalf("expected a *ValidationError, got %T", err)
+		}
+		if validationErr.Path != "/tmp/cli.yml" {
+			t.Fatalf("expected the error to name the settings file, got %q", validationErr.Path)
+		}
+	}
+
+	cfg.Profiles = map[string]*Profile{
+		"default": {Host: "https://circleci.com"},
+	}
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected an error for an invalid profile")
+	}
+	if err.Error() != `/tmp/cli.yml: profiles.default: endpoint must not be empty` {
+		t.Fatalf("unexpected error message: %s", err)
+	}
+}
+
+func TestMigrateSchema(t *testing.T) {
+	cfg := &Config{}
+	cfg.migrateSchema()
+
+	if cfg.SchemaVersion != currentSchemaVersion {
+		t.Fatalf("expected SchemaVersion %d, got %d", currentSchemaVersion, cfg.SchemaVersion)
+	}
+}